@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestZip(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestScanArchivesExemptsContainerFromExtensionFilter pins the fix for a
+// regression where --count go dropped an archive (e.g. data.zip) before it
+// was ever opened, because the container's own extension didn't match the
+// allowlist, hiding the .go files inside it.
+func TestScanArchivesExemptsContainerFromExtensionFilter(t *testing.T) {
+	dir := t.TempDir()
+	zipData := writeTestZip(t, map[string]string{"inner.go": "package main\nfunc F() {}\n"})
+	if err := os.WriteFile(filepath.Join(dir, "data.zip"), zipData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := scanOptions{Archives: true, MaxArchiveDepth: 5, Jobs: 1}
+	count := parseExtensions("go")
+	result, err := scan(os.DirFS(dir), defaultSkipDirs, count, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Files != 1 {
+		t.Fatalf("got %d files, want 1 (inner.go found inside data.zip)", result.Files)
+	}
+	if len(result.PerFile) != 1 || result.PerFile[0].Path != "data.zip!inner.go" {
+		t.Fatalf("got entries %+v, want a single data.zip!inner.go", result.PerFile)
+	}
+}
+
+// TestScanArchivesNestedZip exercises archive-in-archive recursion through
+// the same extension allowlist.
+func TestScanArchivesNestedZip(t *testing.T) {
+	dir := t.TempDir()
+	inner := writeTestZip(t, map[string]string{"inner.go": "package main\n"})
+	outer := writeTestZip(t, map[string]string{"nested.zip": string(inner)})
+	if err := os.WriteFile(filepath.Join(dir, "outer.zip"), outer, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := scanOptions{Archives: true, MaxArchiveDepth: 5, Jobs: 1}
+	count := parseExtensions("go")
+	result, err := scan(os.DirFS(dir), defaultSkipDirs, count, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.PerFile) != 1 || result.PerFile[0].Path != "outer.zip!nested.zip!inner.go" {
+		t.Fatalf("got entries %+v, want a single outer.zip!nested.zip!inner.go", result.PerFile)
+	}
+}
+
+// TestScanArchivesMaxDepth confirms recursion stops once MaxArchiveDepth is
+// exceeded instead of recursing indefinitely.
+func TestScanArchivesMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	inner := writeTestZip(t, map[string]string{"inner.go": "package main\n"})
+	outer := writeTestZip(t, map[string]string{"nested.zip": string(inner)})
+	if err := os.WriteFile(filepath.Join(dir, "outer.zip"), outer, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := scanOptions{Archives: true, MaxArchiveDepth: 0, Jobs: 1}
+	result, err := scan(os.DirFS(dir), defaultSkipDirs, nil, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range result.PerFile {
+		if e.Path == "outer.zip!nested.zip!inner.go" {
+			t.Fatalf("got %+v, expected recursion to stop before reaching the nested archive's contents", result.PerFile)
+		}
+	}
+}
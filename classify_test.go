@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestClassifyLinesStrictVsLoose(t *testing.T) {
+	src := "x := 1 // trailing comment\n"
+	spec := languagesByExt[".go"]
+
+	code, comment, blank, lines, _, err := classifyLines(bufio.NewReader(strings.NewReader(src)), spec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 1 || comment != 0 || blank != 0 || lines != 1 {
+		t.Fatalf("strict: got code=%d comment=%d blank=%d lines=%d, want code=1 comment=0 blank=0 lines=1", code, comment, blank, lines)
+	}
+
+	code, comment, blank, lines, _, err = classifyLines(bufio.NewReader(strings.NewReader(src)), spec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 || comment != 1 || blank != 0 || lines != 1 {
+		t.Fatalf("loose: got code=%d comment=%d blank=%d lines=%d, want code=0 comment=1 blank=0 lines=1", code, comment, blank, lines)
+	}
+}
+
+func TestClassifyLinesBlockComment(t *testing.T) {
+	src := "print(1)\n--[[\nthis is inside\na block comment\n]]\nprint(2)\n"
+	spec := languagesByExt[".lua"]
+
+	code, comment, blank, lines, _, err := classifyLines(bufio.NewReader(strings.NewReader(src)), spec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines != 6 {
+		t.Fatalf("got lines=%d, want 6", lines)
+	}
+	if code != 2 {
+		t.Fatalf("got code=%d, want 2 (the two print lines)", code)
+	}
+	if comment != 4 {
+		t.Fatalf("got comment=%d, want 4 (open line, two body lines, close line)", comment)
+	}
+	if blank != 0 {
+		t.Fatalf("got blank=%d, want 0", blank)
+	}
+}
+
+func TestDetectLanguageShebangNoExtension(t *testing.T) {
+	spec, ok := detectLanguage("myscript", []byte("#!/usr/bin/env python3\n"))
+	if !ok {
+		t.Fatal("expected shebang sniff to recognize python3")
+	}
+	if spec.Language != "Python" {
+		t.Fatalf("got language %q, want Python", spec.Language)
+	}
+
+	spec, ok = detectLanguage("myscript", []byte("#!/bin/bash\n"))
+	if !ok {
+		t.Fatal("expected shebang sniff to recognize bash")
+	}
+	if spec.Language != "Shell" {
+		t.Fatalf("got language %q, want Shell", spec.Language)
+	}
+
+	if _, ok := detectLanguage("myscript", []byte("no shebang here\n")); ok {
+		t.Fatal("expected no language without a recognized extension or shebang")
+	}
+}
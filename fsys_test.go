@@ -0,0 +1,86 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"testing"
+)
+
+func writeTestTar(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildTarFS(t *testing.T) {
+	data := writeTestTar(t, map[string]string{
+		"a.go":     "package main\nfunc F() {}\n",
+		"sub/b.go": "package sub\n",
+		"./c.go":   "package main\n",
+	})
+
+	fsys, err := buildTarFS(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := scanOptions{Jobs: 1}
+	count := parseExtensions("go")
+	result, err := scan(fsys, defaultSkipDirs, count, false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Files != 3 {
+		t.Fatalf("got %d files, want 3, entries: %+v", result.Files, result.PerFile)
+	}
+
+	got := map[string]bool{}
+	for _, e := range result.PerFile {
+		got[e.Path] = true
+	}
+	for _, want := range []string{"a.go", "sub/b.go", "c.go"} {
+		if !got[want] {
+			t.Errorf("missing entry %q in %v", want, got)
+		}
+	}
+}
+
+func TestTarFSFromPathStdinMarker(t *testing.T) {
+	data := writeTestTar(t, map[string]string{"a.go": "package main\n"})
+	tmp, err := os.CreateTemp(t.TempDir(), "*.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	oldStdin := os.Stdin
+	os.Stdin = tmp
+	defer func() { os.Stdin = oldStdin }()
+
+	fsys, err := tarFSFromPath("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Open("a.go"); err != nil {
+		t.Fatalf("expected a.go to be present in the built fs.FS: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompileGitignorePattern(t *testing.T) {
+	cases := []struct {
+		name       string
+		pattern    string
+		input      string
+		match      bool
+		wantDir    bool
+		wantAnchor bool
+	}{
+		{"plain match", "*.log", "a.log", true, false, false},
+		{"plain no match", "*.log", "a.txt", false, false, false},
+		{"trailing slash is dir-only", "build/", "build", true, true, false},
+		{"leading slash anchors", "/vendor", "vendor", true, false, true},
+		{"internal slash also anchors", "sub/secret.txt", "sub/secret.txt", true, false, true},
+		{"double-star matches across dirs", "**/secret.txt", "a/b/secret.txt", true, false, true},
+		{"trailing double-star matches anything under", "build/**", "build/a/b.txt", true, false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rule, ok := compileGitignorePattern(c.pattern)
+			if !ok {
+				t.Fatalf("compileGitignorePattern(%q) rejected the pattern", c.pattern)
+			}
+			if rule.dirOnly != c.wantDir {
+				t.Errorf("pattern %q: dirOnly=%v, want %v", c.pattern, rule.dirOnly, c.wantDir)
+			}
+			if rule.anchored != c.wantAnchor {
+				t.Errorf("pattern %q: anchored=%v, want %v", c.pattern, rule.anchored, c.wantAnchor)
+			}
+			if got := rule.re.MatchString(c.input); got != c.match {
+				t.Errorf("pattern %q against %q: got match=%v, want %v", c.pattern, c.input, got, c.match)
+			}
+		})
+	}
+}
+
+func TestCompileGitignorePatternNegation(t *testing.T) {
+	rule, ok := compileGitignorePattern("!secret.txt")
+	if !ok {
+		t.Fatal("expected pattern to compile")
+	}
+	if !rule.negate {
+		t.Fatal("expected negate=true for a leading !")
+	}
+	if !rule.re.MatchString("secret.txt") {
+		t.Fatal("expected the pattern body (without !) to still match")
+	}
+}
+
+// fixtureGitignoreFS builds a minimal fs.FS with a root .gitignore that
+// excludes *.log and /build/, and a nested sub/.gitignore that re-includes
+// sub/secret.txt, exercising negation and root-to-leaf precedence together.
+func fixtureGitignoreFS() fstest.MapFS {
+	mk := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+	return fstest.MapFS{
+		".gitignore":          mk("*.log\n/build/\nsub/secret.txt\n"),
+		"sub/.gitignore":      mk("!secret.txt\n"),
+		"a.log":               mk(""),
+		"build/output.bin":    mk(""),
+		"sub/secret.txt":      mk(""),
+		"sub/other.txt":       mk(""),
+		"keep.txt":            mk(""),
+		"sub/nested/deep.txt": mk(""),
+	}
+}
+
+func TestGitignoreStackIgnored(t *testing.T) {
+	fsys := fixtureGitignoreFS()
+	ig, err := newGitignoreStack(fsys, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"build", true, true},
+		{"sub/secret.txt", false, false}, // re-included by sub/.gitignore's negation
+		{"sub/other.txt", false, false},
+		{"keep.txt", false, false},
+	}
+	for _, c := range cases {
+		if got := ig.ignored(c.path, c.isDir); got != c.ignored {
+			t.Errorf("ignored(%q, isDir=%v) = %v, want %v", c.path, c.isDir, got, c.ignored)
+		}
+	}
+}
+
+func TestGitignoreStackDisabled(t *testing.T) {
+	fsys := fixtureGitignoreFS()
+	ig, err := newGitignoreStack(fsys, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ig.ignored("a.log", false) {
+		t.Fatal("expected ignored to always report false when respectGitignore is off and no --ignore-file is set")
+	}
+}
@@ -1,17 +1,29 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"testing/fstest"
 )
 
 var defaultSkipDirs = map[string]struct{}{
@@ -43,19 +55,200 @@ type stats struct {
 	Files   int64
 	Lines   int64
 	Bytes   int64
+	Code    int64
+	Comment int64
+	Blank   int64
 	PerFile []fileStat
 }
 
+type scanOptions struct {
+	Classify        bool // classify each file's lines as blank/comment/code
+	Strict          bool // in classify mode, require a comment to start the line
+	Archives        bool // recurse into .zip/.tar/.tar.gz files as if they were directories
+	MaxArchiveDepth int  // archive-in-archive nesting limit when Archives is set
+	Jobs            int  // number of worker goroutines; <= 0 means runtime.NumCPU()
+
+	RespectGitignore bool   // honor .gitignore files found at any depth under root
+	IgnoreFile       string // additional ignore-pattern file, applied at the root
+
+	NDJSONWriter io.Writer // if set, scan streams each completed job's entries here as ndjson, bypassing the final sort
+}
+
 type fileStat struct {
-	Path  string
-	Lines int64
-	Bytes int64
+	Path     string `json:"path"`
+	Lines    int64  `json:"lines"`
+	Bytes    int64  `json:"bytes"`
+	Code     int64  `json:"code"`
+	Comment  int64  `json:"comment"`
+	Blank    int64  `json:"blank"`
+	Language string `json:"language,omitempty"`
 }
 
 type extStat struct {
-	Ext   string
-	Files int64
-	Lines int64
+	Ext     string `json:"ext"`
+	Files   int64  `json:"files"`
+	Lines   int64  `json:"lines"`
+	Code    int64  `json:"code"`
+	Comment int64  `json:"comment"`
+	Blank   int64  `json:"blank"`
+}
+
+// commentSpec describes how comments look in a language. This is a
+// heuristic, not a parser: comment-like tokens inside string/char literals
+// are not accounted for.
+type commentSpec struct {
+	Language string
+	Line     []string    // single-line comment tokens, e.g. "//", "#"
+	Block    [][2]string // open/close pairs, e.g. {"/*", "*/"}
+}
+
+var languagesByExt = map[string]commentSpec{
+	".go":   {"Go", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".c":    {"C", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".h":    {"C", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".cc":   {"C++", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".cpp":  {"C++", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".hpp":  {"C++", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".java": {"Java", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".js":   {"JavaScript", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".jsx":  {"JavaScript", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".ts":   {"TypeScript", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".tsx":  {"TypeScript", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".rs":   {"Rust", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".css":  {"CSS", nil, [][2]string{{"/*", "*/"}}},
+	".scss": {"SCSS", []string{"//"}, [][2]string{{"/*", "*/"}}},
+	".py":   {"Python", []string{"#"}, [][2]string{{`"""`, `"""`}, {"'''", "'''"}}},
+	".rb":   {"Ruby", []string{"#"}, [][2]string{{"=begin", "=end"}}},
+	".sh":   {"Shell", []string{"#"}, nil},
+	".bash": {"Shell", []string{"#"}, nil},
+	".pl":   {"Perl", []string{"#"}, nil},
+	".yaml": {"YAML", []string{"#"}, nil},
+	".yml":  {"YAML", []string{"#"}, nil},
+	".toml": {"TOML", []string{"#"}, nil},
+	".sql":  {"SQL", []string{"--"}, [][2]string{{"/*", "*/"}}},
+	".hs":   {"Haskell", []string{"--"}, [][2]string{{"{-", "-}"}}},
+	".lua":  {"Lua", []string{"--"}, [][2]string{{"--[[", "]]"}}},
+	".html": {"HTML", nil, [][2]string{{"<!--", "-->"}}},
+	".htm":  {"HTML", nil, [][2]string{{"<!--", "-->"}}},
+	".xml":  {"XML", nil, [][2]string{{"<!--", "-->"}}},
+}
+
+// shebangLanguages maps a shebang interpreter name to its languagesByExt key.
+var shebangLanguages = map[string]string{
+	"sh":      ".sh",
+	"bash":    ".bash",
+	"python":  ".py",
+	"python3": ".py",
+	"perl":    ".pl",
+	"node":    ".js",
+	"ruby":    ".rb",
+}
+
+// detectLanguage returns the commentSpec for path, falling back to
+// sniffing a shebang line when the extension is unrecognized.
+func detectLanguage(path string, firstLine []byte) (commentSpec, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if spec, ok := languagesByExt[ext]; ok {
+		return spec, true
+	}
+	if !bytes.HasPrefix(firstLine, []byte("#!")) {
+		return commentSpec{}, false
+	}
+	interpreter := string(bytes.TrimSpace(firstLine[2:]))
+	fields := strings.Fields(interpreter)
+	if len(fields) == 0 {
+		return commentSpec{}, false
+	}
+	name := filepath.Base(fields[0])
+	if name == "env" && len(fields) > 1 {
+		name = fields[1]
+	}
+	if shebangExt, ok := shebangLanguages[name]; ok {
+		return languagesByExt[shebangExt], true
+	}
+	return commentSpec{}, false
+}
+
+// classifyLines buckets each line of reader as blank, comment, or code. In
+// strict mode a line is a comment only when it starts with one; loose mode
+// also matches a comment token appearing later on a code line.
+func classifyLines(reader *bufio.Reader, spec commentSpec, strict bool) (code, comment, blank, lines, size int64, err error) {
+	inBlock := false
+	blockClose := ""
+
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) == 0 && readErr != nil {
+			break
+		}
+		size += int64(len(line))
+		lines++
+
+		trimmed := bytes.TrimLeft(bytes.TrimRight(line, "\r\n"), " \t")
+		switch {
+		case len(bytes.TrimSpace(trimmed)) == 0:
+			blank++
+		case inBlock:
+			if idx := bytes.Index(trimmed, []byte(blockClose)); idx != -1 {
+				inBlock = false
+				rest := bytes.TrimSpace(trimmed[idx+len(blockClose):])
+				if len(rest) > 0 {
+					code++
+				} else {
+					comment++
+				}
+			} else {
+				comment++
+			}
+		default:
+			if isCommentLine(trimmed, spec, strict, &inBlock, &blockClose) {
+				comment++
+			} else {
+				code++
+			}
+		}
+
+		if readErr != nil {
+			if readErr != io.EOF {
+				return 0, 0, 0, 0, 0, readErr
+			}
+			break
+		}
+	}
+	return code, comment, blank, lines, size, nil
+}
+
+// isCommentLine decides whether trimmed (a line with leading whitespace
+// already stripped) is a comment line, opening inBlock/blockClose when it
+// starts a multi-line comment that is not closed on the same line.
+func isCommentLine(trimmed []byte, spec commentSpec, strict bool, inBlock *bool, blockClose *string) bool {
+	// Block-open tokens are checked before line tokens: Lua's "--[[" starts
+	// with "--", so a line-token check that ran first would misclassify
+	// every block comment as a one-liner and never set inBlock.
+	for _, pair := range spec.Block {
+		open, close := []byte(pair[0]), []byte(pair[1])
+		if bytes.HasPrefix(trimmed, open) {
+			if idx := bytes.Index(trimmed[len(open):], close); idx != -1 {
+				rest := bytes.TrimSpace(trimmed[len(open)+idx+len(close):])
+				return len(rest) == 0
+			}
+			*inBlock = true
+			*blockClose = pair[1]
+			return true
+		}
+		if !strict && bytes.Contains(trimmed, open) {
+			return true
+		}
+	}
+	for _, tok := range spec.Line {
+		if bytes.HasPrefix(trimmed, []byte(tok)) {
+			return true
+		}
+		if !strict && bytes.Contains(trimmed, []byte(tok)) {
+			return true
+		}
+	}
+	return false
 }
 
 func main() {
@@ -63,12 +256,30 @@ func main() {
 	countExts := flag.String("count", "", "comma-separated file extensions to count (example: go,md)")
 	includeHidden := flag.Bool("include-hidden", false, "include hidden files/directories (except skipped directories)")
 	rank := flag.Bool("rank", false, "show leaderboards for files and extensions by line count")
+	classify := flag.Bool("classify", false, "classify lines as blank/comment/code for recognized languages")
+	looseComments := flag.Bool("loose-comments", false, "with --classify, count a line as a comment if a comment token appears anywhere on it, not just at the start")
+	archives := flag.Bool("archives", false, "recurse into .zip/.tar/.tar.gz files as if they were directories")
+	maxArchiveDepth := flag.Int("max-archive-depth", 5, "with --archives, how many levels of nested archives to recurse into")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "number of worker goroutines used to read and count files")
+	respectGitignore := flag.Bool("respect-gitignore", false, "skip files and directories excluded by .gitignore files found at any depth")
+	ignoreFile := flag.String("ignore-file", "", "path to an additional gitignore-style file to apply at the root")
+	fromTar := flag.String("from-tar", "", "scan a tar or tar.gz stream instead of the local directory; use - to read it from stdin")
+	fromZip := flag.String("from-zip", "", "scan a .zip file instead of the local directory")
+	var output string
+	flag.StringVar(&output, "output", "text", "output format: text, json, csv, or ndjson")
+	flag.StringVar(&output, "o", "text", "shorthand for --output")
 	flag.Parse()
 	resolvedTop, err := resolveTop(*rank, flag.Args())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
+	switch output {
+	case "text", "json", "csv", "ndjson":
+	default:
+		fmt.Fprintf(os.Stderr, "error: invalid --output value: %s (want text, json, csv, or ndjson)\n", output)
+		os.Exit(1)
+	}
 
 	skip := make(map[string]struct{}, len(defaultSkipDirs))
 	for k := range defaultSkipDirs {
@@ -86,18 +297,77 @@ func main() {
 
 	count := parseExtensions(*countExts)
 
-	result, err := scan(".", skip, count, *includeHidden)
+	opts := scanOptions{
+		Classify:         *classify,
+		Strict:           !*looseComments,
+		Archives:         *archives,
+		MaxArchiveDepth:  *maxArchiveDepth,
+		Jobs:             *jobs,
+		RespectGitignore: *respectGitignore,
+		IgnoreFile:       *ignoreFile,
+	}
+	if output == "ndjson" {
+		opts.NDJSONWriter = os.Stdout
+	}
+	var fsys fs.FS
+	switch {
+	case *fromTar != "" && *fromZip != "":
+		fmt.Fprintf(os.Stderr, "error: --from-tar and --from-zip are mutually exclusive\n")
+		os.Exit(1)
+	case *fromTar != "":
+		tfs, err := tarFSFromPath(*fromTar)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fsys = tfs
+	case *fromZip != "":
+		zr, err := zip.OpenReader(*fromZip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		defer zr.Close()
+		fsys = zr
+	default:
+		fsys = os.DirFS(".")
+	}
+
+	result, err := scan(fsys, skip, count, *includeHidden, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 
+	switch output {
+	case "json":
+		if err := writeJSON(os.Stdout, result, *classify); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "csv":
+		if err := writeCSV(os.Stdout, result.PerFile, *classify); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "ndjson":
+		// Already streamed row-by-row from scan via opts.NDJSONWriter.
+		return
+	}
+
 	fmt.Printf("Files: %s\n", formatWithCommas(result.Files))
 	fmt.Printf("Lines: %s\n", formatWithCommas(result.Lines))
 	fmt.Printf("Bytes: %s\n", formatWithCommas(result.Bytes))
+	if *classify {
+		fmt.Printf("Code: %s\n", formatWithCommas(result.Code))
+		fmt.Printf("Comments: %s\n", formatWithCommas(result.Comment))
+		fmt.Printf("Blank: %s\n", formatWithCommas(result.Blank))
+	}
 	if *rank {
 		printFileLeaderboard(result.PerFile, resolvedTop)
-		printExtensionLeaderboard(result.PerFile, resolvedTop)
+		printExtensionLeaderboard(result.PerFile, resolvedTop, *classify)
 	}
 }
 
@@ -119,73 +389,734 @@ func resolveTop(rank bool, args []string) (int, error) {
 	return n, nil
 }
 
-func scan(root string, skip map[string]struct{}, count map[string]struct{}, includeHidden bool) (stats, error) {
-	var s stats
+type filters struct {
+	Skip          map[string]struct{}
+	Count         map[string]struct{}
+	IncludeHidden bool
+}
+
+// accept reports whether a regular file named name (with ext already
+// lowercased) should be read at all, before any text-sniffing.
+func (f filters) accept(name, lowerName, ext string) bool {
+	if !f.IncludeHidden && strings.HasPrefix(name, ".") {
+		return false
+	}
+	if len(f.Count) > 0 {
+		_, ok := f.Count[ext]
+		return ok
+	}
+	if _, ok := defaultSkipFiles[lowerName]; ok {
+		return false
+	}
+	return ext != "" && ext != ".exe"
+}
+
+// skipDir reports whether a directory (or archive "directory" entry) named
+// name should be pruned from the walk.
+func (f filters) skipDir(name string) bool {
+	if _, ok := f.Skip[name]; ok {
+		return true
+	}
+	return !f.IncludeHidden && strings.HasPrefix(name, ".")
+}
+
+// gitignoreRule is one compiled .gitignore line, plus the git semantics
+// that affect matching (negation, directory-only, anchored).
+type gitignoreRule struct {
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// gitignoreFrame is the compiled rules from a single directory's .gitignore
+// (plus --ignore-file, for the root frame).
+type gitignoreFrame struct {
+	dir   string // "." for the root, else slash-separated relative dir
+	rules []gitignoreRule
+}
+
+// gitignoreStack caches one gitignoreFrame per directory and resolves
+// ignore status root-to-leaf, last match wins, matching git's precedence.
+type gitignoreStack struct {
+	fsys       fs.FS
+	enabled    bool
+	readFiles  bool            // read .gitignore files from fsys (--respect-gitignore)
+	extraRules []gitignoreRule // from --ignore-file, applied at the root alongside any root .gitignore
+	cache      map[string]*gitignoreFrame
+}
+
+// newGitignoreStack reads .gitignore files from fsys. ignoreFilePath, by
+// contrast, is always a real host path, not relative to fsys.
+func newGitignoreStack(fsys fs.FS, respectGitignore bool, ignoreFilePath string) (*gitignoreStack, error) {
+	ig := &gitignoreStack{fsys: fsys, enabled: respectGitignore, readFiles: respectGitignore, cache: map[string]*gitignoreFrame{}}
+	if ignoreFilePath != "" {
+		data, err := os.ReadFile(ignoreFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading ignore file: %w", err)
+		}
+		ig.extraRules = compileGitignoreLines(strings.Split(string(data), "\n"))
+	}
+	if !ig.enabled && ignoreFilePath == "" {
+		return ig, nil
+	}
+	ig.enabled = true
+	return ig, nil
+}
 
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
+// frame returns the compiled rules for directory dir (relative to the scan
+// root, "." for the root itself), reading its .gitignore on first use.
+func (ig *gitignoreStack) frame(dir string) *gitignoreFrame {
+	if f, ok := ig.cache[dir]; ok {
+		return f
+	}
+	f := &gitignoreFrame{dir: dir}
+	if dir == "." {
+		f.rules = append(f.rules, ig.extraRules...)
+	}
+	if ig.readFiles {
+		if data, err := fs.ReadFile(ig.fsys, path.Join(dir, ".gitignore")); err == nil {
+			f.rules = append(f.rules, compileGitignoreLines(strings.Split(string(data), "\n"))...)
 		}
+	}
+	ig.cache[dir] = f
+	return f
+}
+
+// chain returns the frames for dir and every ancestor of dir, root first.
+func (ig *gitignoreStack) chain(dir string) []*gitignoreFrame {
+	if dir == "." || dir == "" {
+		return []*gitignoreFrame{ig.frame(".")}
+	}
+	parts := strings.Split(dir, "/")
+	chain := make([]*gitignoreFrame, 0, len(parts)+1)
+	chain = append(chain, ig.frame("."))
+	for i := range parts {
+		chain = append(chain, ig.frame(path.Join(parts[:i+1]...)))
+	}
+	return chain
+}
 
-		name := d.Name()
-		if d.IsDir() {
-			if _, ok := skip[name]; ok && path != root {
-				return filepath.SkipDir
+// ignored reports whether relPath (slash-separated, relative to the scan
+// root) is excluded by the accumulated .gitignore rules. isDir must reflect
+// whether relPath itself is a directory, since dirOnly patterns only match
+// directories.
+func (ig *gitignoreStack) ignored(relPath string, isDir bool) bool {
+	if !ig.enabled {
+		return false
+	}
+	dir := path.Dir(relPath)
+	name := path.Base(relPath)
+
+	ignored := false
+	for _, f := range ig.chain(dir) {
+		relFromFrame := relPath
+		if f.dir != "." {
+			relFromFrame = strings.TrimPrefix(relPath, f.dir+"/")
+		}
+		for _, rule := range f.rules {
+			if rule.dirOnly && !isDir {
+				continue
 			}
-			if !includeHidden && strings.HasPrefix(name, ".") && path != root {
-				return filepath.SkipDir
+			candidate := name
+			if rule.anchored {
+				candidate = relFromFrame
+			}
+			if rule.re.MatchString(candidate) {
+				ignored = !rule.negate
 			}
-			return nil
 		}
+	}
+	return ignored
+}
 
-		if !d.Type().IsRegular() {
-			return nil
+// compileGitignoreLines parses the lines of a .gitignore-style file into
+// rules, skipping comments and blank lines per git's format.
+func compileGitignoreLines(lines []string) []gitignoreRule {
+	var rules []gitignoreRule
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-		if !includeHidden && strings.HasPrefix(name, ".") {
-			return nil
+		rule, ok := compileGitignorePattern(line)
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// compileGitignorePattern turns one raw .gitignore line into a
+// gitignoreRule, translating the glob syntax (*, ?, [...], **) into an
+// anchored regular expression.
+func compileGitignorePattern(line string) (gitignoreRule, bool) {
+	var rule gitignoreRule
+
+	if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+		line = line[1:]
+	} else if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	if strings.HasSuffix(line, "/") && !strings.HasSuffix(line, "\\/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	if strings.HasPrefix(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	} else if strings.Contains(line, "/") {
+		// A slash anywhere but the very end also anchors the pattern to
+		// the directory holding the .gitignore file, per git's rules.
+		rule.anchored = true
+	}
+
+	rule.re = regexp.MustCompile("^" + globToRegexp(line) + "$")
+	return rule, true
+}
+
+// globToRegexp translates gitignore glob syntax to a regexp fragment:
+// "**" matches across path separators, "*" and "?" don't, and "[...]"
+// character classes pass through mostly as-is.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				// consume a following slash so "**/" behaves like
+				// "zero or more dirs"; a slash before "**" stays literal
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := i + 1
+			for j < len(runes) && runes[j] != ']' {
+				j++
+			}
+			if j < len(runes) {
+				b.WriteByte('[')
+				b.WriteString(string(runes[i+1 : j]))
+				b.WriteByte(']')
+				i = j
+			} else {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+type scanJob struct {
+	path string
+}
+
+type jobResult struct {
+	entries []fileStat
+	err     error
+}
+
+// scanPath opens p from fsys and either recurses into it as an archive or
+// runs processEntry. Permission errors are swallowed rather than failing
+// the scan, so one unreadable file doesn't halt the rest.
+func scanPath(fsys fs.FS, p string, lowerName string, f filters, opts scanOptions) ([]fileStat, error) {
+	af, openErr := fsys.Open(p)
+	if openErr != nil {
+		if errors.Is(openErr, fs.ErrPermission) {
+			return nil, nil
 		}
-		lowerName := strings.ToLower(name)
-		ext := strings.ToLower(filepath.Ext(name))
-		if len(count) > 0 {
-			if _, ok := count[ext]; !ok {
+		return nil, openErr
+	}
+	defer af.Close()
+
+	if opts.Archives {
+		if kind, ok := archiveKind(lowerName); ok {
+			var entries []fileStat
+			err := scanArchive(af, kind, p, 0, f, opts, &entries)
+			return entries, err
+		}
+	}
+
+	requireSniff := len(f.Count) == 0
+	entry, keep, procErr := processEntry(af, path.Base(p), opts, requireSniff)
+	if procErr != nil {
+		if errors.Is(procErr, fs.ErrPermission) {
+			return nil, nil
+		}
+		return nil, procErr
+	}
+	if !keep {
+		return nil, nil
+	}
+	entry.Path = p
+	return []fileStat{entry}, nil
+}
+
+// scan walks fsys and counts lines on a pool of opts.Jobs workers (default
+// runtime.NumCPU()). fs.WalkDir does the cheap filtering on its own
+// goroutine and hands accepted paths to workers over a channel, while the
+// calling goroutine collects the workers' results; the first
+// non-permission error cancels the rest via ctx. PerFile is sorted after
+// collection so output order doesn't depend on worker scheduling.
+func scan(fsys fs.FS, skip map[string]struct{}, count map[string]struct{}, includeHidden bool, opts scanOptions) (stats, error) {
+	f := filters{Skip: skip, Count: count, IncludeHidden: includeHidden}
+
+	ignoreStack, err := newGitignoreStack(fsys, opts.RespectGitignore, opts.IgnoreFile)
+	if err != nil {
+		return stats{}, err
+	}
+
+	workers := opts.Jobs
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := make(chan scanJob, 64)
+	results := make(chan jobResult, 64)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				lowerName := strings.ToLower(path.Base(j.path))
+				entries, err := scanPath(fsys, j.path, lowerName, f, opts)
+				results <- jobResult{entries: entries, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var walkErr error
+	go func() {
+		walkErr = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return fs.SkipAll
+			}
+
+			name := d.Name()
+			if d.IsDir() {
+				if p != "." && (f.skipDir(name) || ignoreStack.ignored(p, true)) {
+					return fs.SkipDir
+				}
 				return nil
 			}
-		} else {
-			if _, ok := defaultSkipFiles[lowerName]; ok {
+			if !d.Type().IsRegular() {
 				return nil
 			}
-			if ext == "" || ext == ".exe" {
+			lowerName := strings.ToLower(name)
+			ext := strings.ToLower(path.Ext(name))
+			if _, isArchive := archiveKind(lowerName); opts.Archives && isArchive {
+				if f.skipDir(name) {
+					return nil
+				}
+			} else if !f.accept(name, lowerName, ext) {
 				return nil
 			}
-			if !isLikelyTextFile(path) {
+			if ignoreStack.ignored(p, false) {
 				return nil
 			}
-		}
 
-		lines, size, err := countLines(path)
-		if err != nil {
-			if errors.Is(err, fs.ErrPermission) {
+			select {
+			case jobs <- scanJob{path: p}:
 				return nil
+			case <-ctx.Done():
+				return fs.SkipAll
+			}
+		})
+		close(jobs)
+	}()
+
+	var (
+		s        stats
+		firstErr error
+		errOnce  sync.Once
+	)
+	for res := range results {
+		if res.err != nil {
+			errOnce.Do(func() {
+				firstErr = res.err
+				cancel()
+			})
+			continue
+		}
+		if opts.NDJSONWriter != nil {
+			if err := writeNDJSON(opts.NDJSONWriter, res.entries); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				continue
 			}
-			return err
 		}
+		s.PerFile = append(s.PerFile, res.entries...)
+	}
+
+	if firstErr != nil {
+		return s, firstErr
+	}
+	if walkErr != nil && !errors.Is(walkErr, context.Canceled) {
+		return s, walkErr
+	}
 
+	sort.Slice(s.PerFile, func(i, j int) bool { return s.PerFile[i].Path < s.PerFile[j].Path })
+	for _, e := range s.PerFile {
 		s.Files++
-		s.Lines += lines
-		s.Bytes += size
-		relPath, err := filepath.Rel(root, path)
+		s.Lines += e.Lines
+		s.Bytes += e.Bytes
+		s.Code += e.Code
+		s.Comment += e.Comment
+		s.Blank += e.Blank
+	}
+
+	return s, nil
+}
+
+// tarFSFromPath reads a tar or tar.gz stream from p ("-" for stdin) and
+// buffers it into an in-memory fs.FS for scan to walk, since tar's
+// sequential format has no random access for scan to use directly.
+func tarFSFromPath(p string) (fs.FS, error) {
+	if p == "-" {
+		return buildTarFS(os.Stdin)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return buildTarFS(f)
+}
+
+// buildTarFS transparently decompresses r if it sniffs as gzip.
+func buildTarFS(r io.Reader) (fs.FS, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
 		if err != nil {
-			relPath = path
+			return nil, err
 		}
-		s.PerFile = append(s.PerFile, fileStat{
-			Path:  relPath,
-			Lines: lines,
-			Bytes: size,
-		})
+		defer gz.Close()
+		return readTarEntries(gz)
+	}
+	return readTarEntries(br)
+}
+
+// readTarEntries copies each regular file from the tar stream r into a
+// fstest.MapFS, which synthesizes any parent directories fs.WalkDir needs.
+// Other entry types (symlinks, devices, dirs) are skipped.
+func readTarEntries(r io.Reader) (fs.FS, error) {
+	tr := tar.NewReader(r)
+	mfs := fstest.MapFS{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(name) {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		mfs[name] = &fstest.MapFile{Data: data, Mode: 0o644}
+	}
+	return mfs, nil
+}
+
+// processEntry reads a file-like stream (a real file or an archive entry)
+// into a fileStat. name is used only for extension/shebang detection, not
+// to open anything. requireSniff skips the binary-content sniff when the
+// caller already restricted to explicit extensions via --count.
+func processEntry(r io.Reader, name string, opts scanOptions, requireSniff bool) (fileStat, bool, error) {
+	br := bufio.NewReaderSize(r, 32*1024)
+
+	if requireSniff {
+		peek, _ := br.Peek(sniffSize)
+		if !isLikelyTextFile(peek) {
+			return fileStat{}, false, nil
+		}
+	}
+
+	if opts.Classify {
+		peek, _ := br.Peek(128)
+		firstLine := peek
+		if idx := bytes.IndexByte(peek, '\n'); idx != -1 {
+			firstLine = peek[:idx]
+		}
+		if spec, ok := detectLanguage(name, firstLine); ok {
+			code, comment, blank, lines, size, err := classifyLines(br, spec, opts.Strict)
+			return fileStat{Lines: lines, Bytes: size, Code: code, Comment: comment, Blank: blank, Language: spec.Language}, true, err
+		}
+	}
+
+	lines, size, err := countLines(br)
+	return fileStat{Lines: lines, Bytes: size}, true, err
+}
+
+func archiveKind(lowerName string) (string, bool) {
+	switch {
+	case strings.HasSuffix(lowerName, ".tar.gz") || strings.HasSuffix(lowerName, ".tgz"):
+		return "tar.gz", true
+	case strings.HasSuffix(lowerName, ".tar"):
+		return "tar", true
+	case strings.HasSuffix(lowerName, ".zip"):
+		return "zip", true
+	}
+	return "", false
+}
+
+// scanArchive recurses into an archive stream as if it were a directory.
+// Entries are reported as "virtualBase!entryName" so the leaderboard still
+// shows where lines came from; depth is checked against
+// opts.MaxArchiveDepth to bound archive-in-archive recursion.
+func scanArchive(r io.Reader, kind, virtualBase string, depth int, f filters, opts scanOptions, out *[]fileStat) error {
+	if depth > opts.MaxArchiveDepth {
 		return nil
-	})
+	}
+
+	switch kind {
+	case "zip":
+		return scanZip(r, virtualBase, depth, f, opts, out)
+	default:
+		return scanTar(r, kind, virtualBase, depth, f, opts, out)
+	}
+}
+
+func scanZip(r io.Reader, virtualBase string, depth int, f filters, opts scanOptions, out *[]fileStat) error {
+	// zip's central directory requires random access, so unlike tar we
+	// cannot stream it without buffering the archive itself in memory.
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		if !archiveEntryAllowed(entry.Name, f, opts) {
+			continue
+		}
+		virtualPath := virtualBase + "!" + entry.Name
+
+		rc, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = scanArchiveEntry(rc, entry.Name, virtualPath, depth, f, opts, out)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanTar(r io.Reader, kind, virtualBase string, depth int, f filters, opts scanOptions, out *[]fileStat) error {
+	src := r
+	if kind == "tar.gz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !archiveEntryAllowed(hdr.Name, f, opts) {
+			continue
+		}
+		virtualPath := virtualBase + "!" + hdr.Name
+		if err := scanArchiveEntry(tr, hdr.Name, virtualPath, depth, f, opts, out); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveEntryAllowed applies the skip/hidden rules to every path component
+// of an archive entry name. A leaf that is itself a recognized archive is
+// treated like a directory name (skipDir only, no extension filtering), so
+// e.g. --count go still recurses into a nested data.zip to reach inner.go.
+func archiveEntryAllowed(name string, f filters, opts scanOptions) bool {
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		isDirComponent := i < len(parts)-1
+		lowerName := strings.ToLower(part)
+		if isDirComponent {
+			if f.skipDir(part) {
+				return false
+			}
+			continue
+		}
+		if _, isArchive := archiveKind(lowerName); opts.Archives && isArchive {
+			if f.skipDir(part) {
+				return false
+			}
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(part))
+		if !f.accept(part, lowerName, ext) {
+			return false
+		}
+	}
+	return true
+}
 
-	return s, err
+// scanArchiveEntry recurses into entryName if it's itself a nested archive
+// (and opts.Archives is set), otherwise counts it like a regular file.
+func scanArchiveEntry(r io.Reader, entryName, virtualPath string, depth int, f filters, opts scanOptions, out *[]fileStat) error {
+	lowerName := strings.ToLower(filepath.Base(entryName))
+	if opts.Archives {
+		if kind, ok := archiveKind(lowerName); ok {
+			return scanArchive(r, kind, virtualPath, depth+1, f, opts, out)
+		}
+	}
+
+	requireSniff := len(f.Count) == 0
+	entry, keep, err := processEntry(r, entryName, opts, requireSniff)
+	if err != nil || !keep {
+		return err
+	}
+	entry.Path = virtualPath
+	*out = append(*out, entry)
+	return nil
+}
+
+// jsonDoc is the document shape emitted by --output json.
+type jsonDoc struct {
+	Files      int64      `json:"files"`
+	Lines      int64      `json:"lines"`
+	Bytes      int64      `json:"bytes"`
+	Code       int64      `json:"code,omitempty"`
+	Comment    int64      `json:"comment,omitempty"`
+	Blank      int64      `json:"blank,omitempty"`
+	PerFile    []fileStat `json:"files_detail"`
+	Extensions []extStat  `json:"extensions"`
+}
+
+// writeJSON emits the full scan result as a single JSON document.
+func writeJSON(w io.Writer, s stats, classify bool) error {
+	doc := jsonDoc{
+		Files:      s.Files,
+		Lines:      s.Lines,
+		Bytes:      s.Bytes,
+		Code:       s.Code,
+		Comment:    s.Comment,
+		Blank:      s.Blank,
+		PerFile:    s.PerFile,
+		Extensions: computeExtStats(s.PerFile, classify),
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// writeNDJSON emits one JSON object per file, one per line, so the output
+// can be piped into `jq` or similar line-oriented tools. For --output
+// ndjson, scan calls this once per completed job via opts.NDJSONWriter, so
+// rows are actually streamed in arrival order as workers finish, instead
+// of waiting for the full sorted result like --output json/csv do.
+func writeNDJSON(w io.Writer, entries []fileStat) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCSV emits one row per file. The code/comment/blank/language columns
+// are only included when classify is true.
+func writeCSV(w io.Writer, entries []fileStat, classify bool) error {
+	cw := csv.NewWriter(w)
+	header := []string{"path", "lines", "bytes", "ext"}
+	if classify {
+		header = append(header, "code", "comment", "blank", "language")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		row := []string{
+			e.Path,
+			strconv.FormatInt(e.Lines, 10),
+			strconv.FormatInt(e.Bytes, 10),
+			strings.ToLower(filepath.Ext(e.Path)),
+		}
+		if classify {
+			row = append(row,
+				strconv.FormatInt(e.Code, 10),
+				strconv.FormatInt(e.Comment, 10),
+				strconv.FormatInt(e.Blank, 10),
+				e.Language,
+			)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
 }
 
 func printFileLeaderboard(entries []fileStat, top int) {
@@ -216,20 +1147,12 @@ func printFileLeaderboard(entries []fileStat, top int) {
 	}
 }
 
-func isLikelyTextFile(path string) bool {
-	f, err := os.Open(path)
-	if err != nil {
-		return false
-	}
-	defer f.Close()
+// sniffSize is how many leading bytes isLikelyTextFile inspects.
+const sniffSize = 8192
 
-	const sniffSize = 8192
-	buf := make([]byte, sniffSize)
-	n, err := f.Read(buf)
-	if err != nil && err != io.EOF {
-		return false
-	}
-	data := buf[:n]
+// isLikelyTextFile heuristically decides, from a leading sniff of a file's
+// content, whether it looks like text worth counting lines in.
+func isLikelyTextFile(data []byte) bool {
 	if len(data) == 0 {
 		return true
 	}
@@ -255,18 +1178,27 @@ func isLikelyTextFile(path string) bool {
 	return float64(nonText)/float64(len(data)) <= 0.30
 }
 
-func printExtensionLeaderboard(entries []fileStat, top int) {
+// computeExtStats rolls entries up by extension, or by Language when
+// classify is true and an entry has one, so e.g. .c and .h both land under
+// "C". Results are sorted by lines desc, then files desc, then name.
+func computeExtStats(entries []fileStat, classify bool) []extStat {
 	byExt := make(map[string]extStat)
 	for _, e := range entries {
-		ext := strings.ToLower(filepath.Ext(e.Path))
-		if ext == "" {
-			ext = "(no extension)"
+		key := strings.ToLower(filepath.Ext(e.Path))
+		if classify && e.Language != "" {
+			key = e.Language
+		}
+		if key == "" {
+			key = "(no extension)"
 		}
-		curr := byExt[ext]
-		curr.Ext = ext
+		curr := byExt[key]
+		curr.Ext = key
 		curr.Files++
 		curr.Lines += e.Lines
-		byExt[ext] = curr
+		curr.Code += e.Code
+		curr.Comment += e.Comment
+		curr.Blank += e.Blank
+		byExt[key] = curr
 	}
 
 	sorted := make([]extStat, 0, len(byExt))
@@ -283,6 +1215,13 @@ func printExtensionLeaderboard(entries []fileStat, top int) {
 		}
 		return sorted[i].Ext < sorted[j].Ext
 	})
+	return sorted
+}
+
+// printExtensionLeaderboard prints the top extensions (or languages, with
+// classify) by line count.
+func printExtensionLeaderboard(entries []fileStat, top int, classify bool) {
+	sorted := computeExtStats(entries, classify)
 	requested := top
 	if requested > len(sorted) {
 		top = len(sorted)
@@ -322,13 +1261,8 @@ func parseExtensions(raw string) map[string]struct{} {
 	return exts
 }
 
-func countLines(path string) (int64, int64, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0, 0, err
-	}
-	defer f.Close()
-
+// countLines counts lines and bytes in r without holding it all in memory.
+func countLines(r io.Reader) (int64, int64, error) {
 	var (
 		lines      int64
 		size       int64
@@ -337,7 +1271,7 @@ func countLines(path string) (int64, int64, error) {
 	)
 
 	for {
-		n, readErr := f.Read(buf)
+		n, readErr := r.Read(buf)
 		if n > 0 {
 			chunk := buf[:n]
 			size += int64(n)
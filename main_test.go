@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// benchTree writes a synthetic package tree (20 directories, 20 files each,
+// 100 lines per file) under b.TempDir() so BenchmarkScan has enough files to
+// make the worker pool's overhead visible.
+func benchTree(b *testing.B) string {
+	b.Helper()
+	dir := b.TempDir()
+	content := strings.Repeat("package main\nfunc F() int { return 1 }\n", 50)
+	for i := 0; i < 20; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("pkg%d", i))
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 20; j++ {
+			p := filepath.Join(sub, fmt.Sprintf("f%d.go", j))
+			if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+// BenchmarkScan compares the serial case (Jobs: 1) against the default
+// worker pool (Jobs: runtime.NumCPU()), demonstrating the speedup from the
+// parallel scan added alongside --jobs.
+func BenchmarkScan(b *testing.B) {
+	dir := benchTree(b)
+	fsys := os.DirFS(dir)
+
+	for _, jobs := range []int{1, runtime.NumCPU()} {
+		jobs := jobs
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			opts := scanOptions{Jobs: jobs}
+			for i := 0; i < b.N; i++ {
+				if _, err := scan(fsys, defaultSkipDirs, nil, false, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
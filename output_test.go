@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	s := stats{
+		Files: 2, Lines: 10, Bytes: 100,
+		PerFile: []fileStat{
+			{Path: "a.go", Lines: 6, Bytes: 60},
+			{Path: "b.go", Lines: 4, Bytes: 40},
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, s, false); err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonDoc
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if doc.Files != 2 || doc.Lines != 10 || doc.Bytes != 100 {
+		t.Fatalf("got %+v, want totals Files=2 Lines=10 Bytes=100", doc)
+	}
+	if len(doc.PerFile) != 2 {
+		t.Fatalf("got %d files_detail entries, want 2", len(doc.PerFile))
+	}
+	if len(doc.Extensions) != 1 || doc.Extensions[0].Ext != ".go" {
+		t.Fatalf("got extensions %+v, want a single .go entry", doc.Extensions)
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	entries := []fileStat{
+		{Path: "a.go", Lines: 6},
+		{Path: "b.go", Lines: 4},
+	}
+	var buf bytes.Buffer
+	if err := writeNDJSON(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2 (one JSON object per file): %q", len(lines), buf.String())
+	}
+	for _, l := range lines {
+		var fs fileStat
+		if err := json.Unmarshal([]byte(l), &fs); err != nil {
+			t.Fatalf("line %q is not a valid JSON object: %v", l, err)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	entries := []fileStat{{Path: "a.go", Lines: 6, Bytes: 60}}
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2 (header + one entry)", len(rows))
+	}
+	if rows[1][0] != "a.go" {
+		t.Errorf("got path %q, want a.go", rows[1][0])
+	}
+}
+
+// TestScanStreamsNDJSON checks that setting opts.NDJSONWriter makes scan
+// emit one JSON object per file directly, without requiring the caller to
+// go through result.PerFile afterward.
+func TestScanStreamsNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("package main\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	opts := scanOptions{Jobs: 2, NDJSONWriter: &buf}
+	if _, err := scan(os.DirFS(dir), defaultSkipDirs, nil, false, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d streamed lines, want 2: %q", len(lines), buf.String())
+	}
+	seen := map[string]bool{}
+	for _, l := range lines {
+		var fs fileStat
+		if err := json.Unmarshal([]byte(l), &fs); err != nil {
+			t.Fatalf("line %q is not valid JSON: %v", l, err)
+		}
+		seen[fs.Path] = true
+	}
+	if !seen["a.go"] || !seen["b.go"] {
+		t.Fatalf("got paths %v, want both a.go and b.go streamed", seen)
+	}
+}
+
+func TestComputeExtStatsGroupsByLanguageWhenClassifying(t *testing.T) {
+	entries := []fileStat{
+		{Path: "a.c", Lines: 5, Language: "C"},
+		{Path: "b.h", Lines: 3, Language: "C"},
+		{Path: "c.py", Lines: 2, Language: "Python"},
+	}
+	stats := computeExtStats(entries, true)
+	if len(stats) != 2 {
+		t.Fatalf("got %d groups, want 2 (C, Python): %+v", len(stats), stats)
+	}
+	var c extStat
+	for _, s := range stats {
+		if s.Ext == "C" {
+			c = s
+		}
+	}
+	if c.Files != 2 || c.Lines != 8 {
+		t.Fatalf("got C group %+v, want Files=2 Lines=8 (merging .c and .h)", c)
+	}
+}